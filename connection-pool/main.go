@@ -49,6 +49,7 @@ type Config struct {
 	PoolSize     int
 	Concurrency  int
 	Operations   int
+	MetricsAddr  string
 }
 
 // BenchmarkResult holds timing and error information
@@ -103,6 +104,9 @@ func main() {
 	fmt.Printf("Concurrency: %d\n", config.Concurrency)
 	fmt.Printf("Operations: %d\n\n", config.Operations)
 
+	startMetricsServer(config.MetricsAddr)
+	fmt.Printf("Metrics: http://localhost%s/metrics\n\n", config.MetricsAddr)
+
 	// Test database connectivity first
 	if err := testConnection(config); err != nil {
 		log.Fatalf("Database connection failed: %v", err)
@@ -122,6 +126,19 @@ func main() {
 	poolResult := benchmarkWithPool(config)
 	results = append(results, poolResult)
 
+	// Postgres additionally gets prepared-statement and pipelined-prepared
+	// benchmarks so users can see the extra speedup prepared statements and
+	// batching provide on top of plain pooling.
+	if config.DatabaseType == "postgres" {
+		fmt.Println("Running benchmark WITH connection pooling + prepared statements...")
+		preparedResult := benchmarkWithPreparedCache(config)
+		results = append(results, preparedResult)
+
+		fmt.Println("Running benchmark WITH connection pooling + prepared statements + pipelining...")
+		pipelineResult := benchmarkWithPipeline(config)
+		results = append(results, pipelineResult)
+	}
+
 	// Display results
 	displayResults(results)
 }
@@ -134,6 +151,7 @@ func parseFlags() Config {
 	flag.IntVar(&config.PoolSize, "pool-size", 10, "Connection pool size")
 	flag.IntVar(&config.Concurrency, "concurrency", 200, "Number of concurrent operations")
 	flag.IntVar(&config.Operations, "operations", 5000, "Total operations to perform")
+	flag.StringVar(&config.MetricsAddr, "metrics-addr", ":9090", "Address to serve Prometheus /metrics on during the run")
 
 	flag.Parse()
 
@@ -264,6 +282,17 @@ func benchmarkWithPool(config Config) BenchmarkResult {
 	}
 	defer pool.Close()
 
+	var stopSampling chan struct{}
+	if pgxPool, ok := pool.(*PgxConnectionPool); ok {
+		fmt.Println(formatPoolStats("before", pgxPool.pool.Stat()))
+		stopSampling = make(chan struct{})
+		go samplePoolStatsUntil(pgxPool.pool, time.Second, stopSampling)
+		defer func() {
+			close(stopSampling)
+			fmt.Println(formatPoolStats("after", pgxPool.pool.Stat()))
+		}()
+	}
+
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 	errors := 0
@@ -295,7 +324,11 @@ func benchmarkWithPool(config Config) BenchmarkResult {
 				query = "SELECT SLEEP(0.01)"
 			}
 
-			if err := pool.Execute(ctx, query); err != nil {
+			opStart := time.Now()
+			err := pool.Execute(ctx, query)
+			operationDuration.WithLabelValues("pooled").Observe(time.Since(opStart).Seconds())
+
+			if err != nil {
 				mu.Lock()
 				errors++
 				mu.Unlock()
@@ -373,20 +406,19 @@ func displayResults(results []BenchmarkResult) {
 		}
 	}
 
-	// Compare results if we have both
-	if len(results) == 2 {
-		nonPool := results[0]
-		pool := results[1]
-
-		if nonPool.Duration > 0 && pool.Duration > 0 {
-			speedup := float64(nonPool.Duration) / float64(pool.Duration)
-			fmt.Printf("\nPERFORMANCE COMPARISON:\n")
-			fmt.Printf("  Connection pooling is %.2fx faster!\n", speedup)
+	// Compare every result against the non-pooled baseline so the extra
+	// prepared-statement and pipelining modes show their incremental gain
+	// too, not just pooling vs no pooling.
+	if len(results) >= 2 {
+		baseline := results[0]
 
-			if speedup > 1 {
-				improvement := ((speedup - 1) * 100)
-				fmt.Printf("  That's a %.1f%% improvement in performance.\n", improvement)
+		fmt.Printf("\nPERFORMANCE COMPARISON (vs %s):\n", baseline.Description)
+		for _, result := range results[1:] {
+			if baseline.Duration <= 0 || result.Duration <= 0 {
+				continue
 			}
+			speedup := float64(baseline.Duration) / float64(result.Duration)
+			fmt.Printf("  %s: %.2fx faster\n", result.Description, speedup)
 		}
 	}
 
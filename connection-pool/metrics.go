@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus gauges mirroring pgxpool.Pool.Stat(), plus a histogram of
+// individual operation latencies labelled by benchmark mode. Exposed on
+// metricsAddr's /metrics so the benchmark doubles as a live pool-saturation
+// diagnostic, not just a one-shot throughput number.
+var (
+	poolAcquireCount         = promauto.NewGauge(prometheus.GaugeOpts{Name: "pool_benchmark_acquire_count", Help: "Cumulative successful acquires from the pool"})
+	poolAcquiredConns        = promauto.NewGauge(prometheus.GaugeOpts{Name: "pool_benchmark_acquired_conns", Help: "Connections currently acquired"})
+	poolCanceledAcquireCount = promauto.NewGauge(prometheus.GaugeOpts{Name: "pool_benchmark_canceled_acquire_count", Help: "Acquires canceled because the context was done"})
+	poolConstructingConns    = promauto.NewGauge(prometheus.GaugeOpts{Name: "pool_benchmark_constructing_conns", Help: "Connections currently being established"})
+	poolIdleConns            = promauto.NewGauge(prometheus.GaugeOpts{Name: "pool_benchmark_idle_conns", Help: "Connections sitting idle in the pool"})
+	poolMaxConns             = promauto.NewGauge(prometheus.GaugeOpts{Name: "pool_benchmark_max_conns", Help: "Configured maximum pool size"})
+	poolTotalConns           = promauto.NewGauge(prometheus.GaugeOpts{Name: "pool_benchmark_total_conns", Help: "Total connections currently open (idle + acquired + constructing)"})
+	poolEmptyAcquireCount    = promauto.NewGauge(prometheus.GaugeOpts{Name: "pool_benchmark_empty_acquire_count", Help: "Acquires that had to wait because no idle connection was available"})
+	poolAcquireDuration      = promauto.NewGauge(prometheus.GaugeOpts{Name: "pool_benchmark_acquire_duration_seconds", Help: "Cumulative time spent waiting on acquires"})
+
+	operationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pool_benchmark_operation_duration_seconds",
+		Help:    "Per-operation latency, labelled by benchmark mode",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"mode"})
+)
+
+// startMetricsServer exposes /metrics on addr for the duration of the
+// benchmark run. Errors are logged, not fatal, since metrics are a nice-to-
+// have on top of the printed before/after snapshots.
+func startMetricsServer(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil && err != http.ErrServerClosed {
+			log.Printf("metrics server stopped: %v", err)
+		}
+	}()
+}
+
+// recordPoolStats copies a pgxpool.Stat snapshot into the package-level
+// gauges above.
+func recordPoolStats(stat *pgxpool.Stat) {
+	poolAcquireCount.Set(float64(stat.AcquireCount()))
+	poolAcquiredConns.Set(float64(stat.AcquiredConns()))
+	poolCanceledAcquireCount.Set(float64(stat.CanceledAcquireCount()))
+	poolConstructingConns.Set(float64(stat.ConstructingConns()))
+	poolIdleConns.Set(float64(stat.IdleConns()))
+	poolMaxConns.Set(float64(stat.MaxConns()))
+	poolTotalConns.Set(float64(stat.TotalConns()))
+	poolEmptyAcquireCount.Set(float64(stat.EmptyAcquireCount()))
+	poolAcquireDuration.Set(stat.AcquireDuration().Seconds())
+}
+
+// formatPoolStats renders a pgxpool.Stat snapshot for the benchmark output,
+// so `acquired ≈ max` and a non-zero empty-acquire count are visible right
+// next to the throughput numbers instead of needing a separate dashboard.
+func formatPoolStats(label string, stat *pgxpool.Stat) string {
+	return fmt.Sprintf(
+		"%s pool stats: acquired=%d/%d idle=%d constructing=%d total=%d empty_acquires=%d canceled_acquires=%d acquire_duration=%v",
+		label, stat.AcquiredConns(), stat.MaxConns(), stat.IdleConns(), stat.ConstructingConns(),
+		stat.TotalConns(), stat.EmptyAcquireCount(), stat.CanceledAcquireCount(), stat.AcquireDuration(),
+	)
+}
+
+// samplePoolStatsUntil records pool stats into the gauges every interval
+// until done is closed, so /metrics reflects live state while a benchmark
+// is running rather than only a single before/after snapshot.
+func samplePoolStatsUntil(pool *pgxpool.Pool, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			recordPoolStats(pool.Stat())
+		}
+	}
+}
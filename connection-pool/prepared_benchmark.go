@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// pipelineBatchSize is how many pg_sleep calls get folded into a single
+// SendBatch round trip in benchmarkWithPipeline.
+const pipelineBatchSize = 20
+
+// benchmarkWithPreparedCache re-runs the pooled benchmark but routes every
+// operation through a PgxPreparedStatementCache instead of sending the raw
+// query string each time, so PostgreSQL only parses/plans it once per
+// connection.
+func benchmarkWithPreparedCache(config Config) BenchmarkResult {
+	pool, err := newBenchPool(config)
+	if err != nil {
+		return BenchmarkResult{Description: "Pooled + prepared statements", Errors: 1}
+	}
+	defer pool.Close()
+
+	cache := NewPgxPreparedStatementCache(pool)
+
+	fmt.Println(formatPoolStats("before", pool.Stat()))
+	stopSampling := make(chan struct{})
+	go samplePoolStatsUntil(pool, time.Second, stopSampling)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	errors, successful := 0, 0
+
+	start := time.Now()
+	semaphore := make(chan struct{}, config.Concurrency)
+
+	for i := 0; i < config.Operations; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			opStart := time.Now()
+			err := cache.Exec(ctx)
+			operationDuration.WithLabelValues("prepared").Observe(time.Since(opStart).Seconds())
+
+			if err != nil {
+				mu.Lock()
+				errors++
+				mu.Unlock()
+			} else {
+				mu.Lock()
+				successful++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(stopSampling)
+	fmt.Println(formatPoolStats("after", pool.Stat()))
+
+	return BenchmarkResult{
+		Duration:    time.Since(start),
+		Errors:      errors,
+		Successful:  successful,
+		Description: "Pooled + prepared statements",
+	}
+}
+
+// benchmarkWithPipeline batches pipelineBatchSize prepared-statement calls
+// into a single pgx SendBatch per goroutine, so the driver pipelines them
+// over the wire instead of waiting for a round trip per query on top of the
+// prepared-statement savings.
+func benchmarkWithPipeline(config Config) BenchmarkResult {
+	pool, err := newBenchPool(config)
+	if err != nil {
+		return BenchmarkResult{Description: "Pooled + prepared + pipelined", Errors: 1}
+	}
+	defer pool.Close()
+
+	fmt.Println(formatPoolStats("before", pool.Stat()))
+	stopSampling := make(chan struct{})
+	go samplePoolStatsUntil(pool, time.Second, stopSampling)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	errors, successful := 0, 0
+
+	start := time.Now()
+	semaphore := make(chan struct{}, config.Concurrency)
+
+	batches := (config.Operations + pipelineBatchSize - 1) / pipelineBatchSize
+	for i := 0; i < batches; i++ {
+		opsInBatch := pipelineBatchSize
+		if remaining := config.Operations - i*pipelineBatchSize; remaining < opsInBatch {
+			opsInBatch = remaining
+		}
+
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			conn, err := pool.Acquire(ctx)
+			if err != nil {
+				mu.Lock()
+				errors += n
+				mu.Unlock()
+				return
+			}
+			defer conn.Release()
+
+			if _, err := conn.Conn().Prepare(ctx, benchQueryName, benchQuery); err != nil {
+				mu.Lock()
+				errors += n
+				mu.Unlock()
+				return
+			}
+
+			batch := &pgx.Batch{}
+			for j := 0; j < n; j++ {
+				batch.Queue(benchQueryName)
+			}
+
+			batchStart := time.Now()
+			br := conn.Conn().SendBatch(ctx, batch)
+			ok := 0
+			for j := 0; j < n; j++ {
+				if _, err := br.Exec(); err != nil {
+					break
+				}
+				ok++
+			}
+			br.Close()
+			operationDuration.WithLabelValues("pipelined").Observe(time.Since(batchStart).Seconds())
+
+			mu.Lock()
+			successful += ok
+			errors += n - ok
+			mu.Unlock()
+		}(opsInBatch)
+	}
+
+	wg.Wait()
+	close(stopSampling)
+	fmt.Println(formatPoolStats("after", pool.Stat()))
+
+	return BenchmarkResult{
+		Duration:    time.Since(start),
+		Errors:      errors,
+		Successful:  successful,
+		Description: "Pooled + prepared + pipelined",
+	}
+}
+
+// newBenchPool opens a pgxpool.Pool sized the same way createConnectionPool
+// does for the plain pooled benchmark, so all three postgres modes compare
+// apples to apples.
+func newBenchPool(config Config) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(config.DSN)
+	if err != nil {
+		return nil, err
+	}
+	poolConfig.MaxConns = int32(config.PoolSize)
+	poolConfig.MinConns = int32(config.PoolSize / 4)
+
+	return pgxpool.NewWithConfig(context.Background(), poolConfig)
+}
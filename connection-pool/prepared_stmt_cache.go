@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// benchQueryName/benchQuery are the single query this benchmark exercises.
+// Naming it once means every mode (unprepared, prepared, prepared+pipeline)
+// prepares under the same stable statement name.
+const (
+	benchQueryName = "bench_sleep"
+	benchQuery     = "SELECT pg_sleep(0.01)"
+)
+
+// PgxPreparedStatementCache lazily prepares benchQueryName on each pooled
+// connection the first time it's acquired, then reuses the prepared
+// statement on every subsequent acquire of that same connection. pgx
+// connections are not guaranteed to be reused across calls, so the cache is
+// keyed per-connection rather than once globally - a connection that has
+// never seen benchQueryName still needs a one-time PrepareContext.
+type PgxPreparedStatementCache struct {
+	pool *pgxpool.Pool
+
+	mu       sync.Mutex
+	prepared map[string]bool // conn identity -> already prepared
+
+	hits   int
+	misses int
+}
+
+// NewPgxPreparedStatementCache wraps pool with a per-connection prepared
+// statement cache.
+func NewPgxPreparedStatementCache(pool *pgxpool.Pool) *PgxPreparedStatementCache {
+	return &PgxPreparedStatementCache{
+		pool:     pool,
+		prepared: make(map[string]bool),
+	}
+}
+
+// connKey identifies a pooled connection stably enough to track whether
+// we've already prepared a statement on it.
+func connKey(conn *pgxpool.Conn) string {
+	return fmt.Sprintf("%p", conn.Conn())
+}
+
+// Exec acquires a connection, preparing benchQueryName on it if this is the
+// first time we've seen that connection, then executes the prepared
+// statement.
+func (c *PgxPreparedStatementCache) Exec(ctx context.Context) error {
+	conn, err := c.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	key := connKey(conn)
+
+	c.mu.Lock()
+	alreadyPrepared := c.prepared[key]
+	if !alreadyPrepared {
+		c.prepared[key] = true
+		c.misses++
+	} else {
+		c.hits++
+	}
+	c.mu.Unlock()
+
+	if !alreadyPrepared {
+		if _, err := conn.Conn().Prepare(ctx, benchQueryName, benchQuery); err != nil {
+			return err
+		}
+	}
+
+	_, err = conn.Conn().Exec(ctx, benchQueryName)
+	return err
+}
+
+// Stats reports prepare cache hits/misses for the benchmark's pool-stat
+// snapshot.
+func (c *PgxPreparedStatementCache) Stats() (hits, misses int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
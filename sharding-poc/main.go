@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type User struct {
@@ -20,45 +24,151 @@ type User struct {
 }
 
 type ShardManager struct {
-	shards []*sql.DB
+	mu            sync.RWMutex
+	shards        map[int]*ShardConn
+	router        ShardRouter
+	txCoordinator *ShardTxCoordinator
 }
 
-// Initialize shard connections
+// defaultRouterStrategy is used when ROUTER_STRATEGY is unset, matching the
+// router NewShardManager has always built.
+const defaultRouterStrategy = "consistent_hash"
+
+// newRouter builds the ShardRouter selected by strategy over shardIDs.
+// Unknown strategies fall back to defaultRouterStrategy rather than failing
+// startup, since a typo'd env var shouldn't take the whole service down.
+func newRouter(strategy string, shardIDs []int) ShardRouter {
+	switch strategy {
+	case "modulo":
+		return NewModuloRouter(shardIDs)
+	case "range":
+		// RangeRouter needs an upper bound per shard except the last; without
+		// per-shard config to source those from, split the int range evenly.
+		bounds := make([]int, 0, len(shardIDs)-1)
+		step := (1 << 31) / len(shardIDs)
+		for i := 1; i < len(shardIDs); i++ {
+			bounds = append(bounds, i*step)
+		}
+		return NewRangeRouter(shardIDs, bounds)
+	case "consistent_hash", "":
+		return NewConsistentHashRouter(shardIDs)
+	default:
+		log.Printf("⚠️  unknown ROUTER_STRATEGY %q, falling back to %s", strategy, defaultRouterStrategy)
+		return NewConsistentHashRouter(shardIDs)
+	}
+}
+
+// Initialize shard connections. Each shard is read from a
+// SHARD_N_PRIMARY_DSN env var plus an optional comma-separated
+// SHARD_N_REPLICA_DSNS of read replicas; REPLICA_POLICY selects how reads
+// are balanced across them (round_robin, least_latency, random).
+// ROUTER_STRATEGY picks the ShardRouter implementation: consistent_hash
+// (default), modulo, or range.
 func NewShardManager() (*ShardManager, error) {
-	shard0DSN := os.Getenv("SHARD_0_DSN")
-	shard1DSN := os.Getenv("SHARD_1_DSN")
+	policy := ReplicaPolicy(os.Getenv("REPLICA_POLICY"))
+	if policy == "" {
+		policy = defaultReplicaPolicy
+	}
 
-	shard0, err := sql.Open("postgres", shard0DSN)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to shard 0: %w", err)
+	ctx := context.Background()
+	shards := make(map[int]*ShardConn, 2)
+	for i := 0; i < 2; i++ {
+		primaryDSN := os.Getenv(fmt.Sprintf("SHARD_%d_PRIMARY_DSN", i))
+		if primaryDSN == "" {
+			primaryDSN = os.Getenv(fmt.Sprintf("SHARD_%d_DSN", i)) // back-compat with the pre-replica env layout
+		}
+		var replicaDSNs []string
+		if raw := os.Getenv(fmt.Sprintf("SHARD_%d_REPLICA_DSNS", i)); raw != "" {
+			replicaDSNs = strings.Split(raw, ",")
+		}
+
+		sc, err := newShardConn(ctx, primaryDSN, replicaDSNs, policy)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to shard %d: %w", i, err)
+		}
+		shards[i] = sc
 	}
 
-	shard1, err := sql.Open("postgres", shard1DSN)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to shard 1: %w", err)
+	log.Println("✅ Successfully connected to all shards (primaries + replicas)")
+
+	strategy := os.Getenv("ROUTER_STRATEGY")
+	if strategy == "" {
+		strategy = defaultRouterStrategy
 	}
 
-	// Test connections
-	if err := shard0.Ping(); err != nil {
-		return nil, fmt.Errorf("shard 0 ping failed: %w", err)
+	return &ShardManager{
+		shards: shards,
+		router: newRouter(strategy, []int{0, 1}),
+	}, nil
+}
+
+// AddShard registers a new physical shard connection (no replicas) and
+// adds it to the router's ring. It does not move any data on its own -
+// call StartResharding to stream the rows that now belong on the new shard.
+func (sm *ShardManager) AddShard(shardID int, db *sql.DB, weight int) {
+	sc := &ShardConn{primary: db, primaryCache: NewPreparedStatementCache(db), policy: defaultReplicaPolicy}
+
+	sm.mu.Lock()
+	sm.shards[shardID] = sc
+	sm.mu.Unlock()
+
+	sm.router.AddShard(shardID, weight)
+}
+
+// RemoveShard drops a shard connection and removes it from the router ring.
+// Callers are responsible for having migrated its data off first.
+func (sm *ShardManager) RemoveShard(shardID int) {
+	sm.router.RemoveShard(shardID)
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if sc, ok := sm.shards[shardID]; ok {
+		sc.close()
 	}
+	delete(sm.shards, shardID)
+}
 
-	if err := shard1.Ping(); err != nil {
-		return nil, fmt.Errorf("shard 1 ping failed: %w", err)
+// connForUser returns the ShardConn userID routes to, alongside the shard
+// ID itself. It errors if every shard has been removed from the router.
+func (sm *ShardManager) connForUser(userID int) (int, *ShardConn, error) {
+	shardID, err := sm.router.ShardFor(userID)
+	if err != nil {
+		return 0, nil, err
 	}
 
-	log.Println("✅ Successfully connected to both shards")
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return shardID, sm.shards[shardID], nil
+}
 
-	return &ShardManager{
-		shards: []*sql.DB{shard0, shard1},
-	}, nil
+// shardIDs returns the current set of shard IDs known to the manager.
+func (sm *ShardManager) shardIDs() []int {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.shardIDsLocked()
+}
+
+// shardIDsLocked is shardIDs without acquiring the lock; callers must
+// already hold sm.mu (read or write).
+func (sm *ShardManager) shardIDsLocked() []int {
+	ids := make([]int, 0, len(sm.shards))
+	for id := range sm.shards {
+		ids = append(ids, id)
+	}
+	return ids
 }
 
-// Hash-based sharding: userID % number_of_shards
-func (sm *ShardManager) getShardForUser(userID int) *sql.DB {
-	shardIndex := userID % len(sm.shards)
-	log.Printf("🔀 Routing userID %d to shard %d", userID, shardIndex)
-	return sm.shards[shardIndex]
+// shardByID returns the primary *sql.DB for a given shard ID, if known.
+// Writes and anything needing transactional guarantees (resharding, 2PC)
+// always go through the primary.
+func (sm *ShardManager) shardByID(shardID int) (*sql.DB, bool) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	sc, ok := sm.shards[shardID]
+	if !ok {
+		return nil, false
+	}
+	return sc.primary, true
 }
 
 // Get user from appropriate shard
@@ -70,24 +180,44 @@ func (sm *ShardManager) getUser(c *gin.Context) {
 		return
 	}
 
-	// Determine which shard to query based on hash
-	shard := sm.getShardForUser(userID)
+	// Determine which shard to query based on the router, then pick a read
+	// target: a healthy replica under the configured policy, or the
+	// primary if ?consistency=strong was requested (read-your-writes) or
+	// no replica is currently healthy.
+	shardID, sc, err := sm.connForUser(userID)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	c.Set("shard", shardID)
+	log.Printf("🔀 Routing userID %d to shard %d", userID, shardID)
+
+	cache := sc.readTarget()
+	if c.Query("consistency") == "strong" {
+		cache = sc.writeTarget()
+	}
+
+	stmt, err := cache.Stmt(queryGetUser)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	var user User
-	query := `SELECT user_id, name, email, created_at FROM users WHERE user_id = $1`
-	err = shard.QueryRow(query, userID).Scan(&user.UserID, &user.Name, &user.Email, &user.CreatedAt)
+	err = stmt.QueryRow(userID).Scan(&user.UserID, &user.Name, &user.Email, &user.CreatedAt)
 
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	} else if err != nil {
+		recordSQLError(shardID, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"user":       user,
-		"shard_used": userID % len(sm.shards),
+		"shard_used": shardID,
 		"routing":    "application_layer",
 	})
 }
@@ -100,53 +230,35 @@ func (sm *ShardManager) createUser(c *gin.Context) {
 		return
 	}
 
-	shard := sm.getShardForUser(user.UserID)
+	shardID, sc, err := sm.connForUser(user.UserID)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	c.Set("shard", shardID)
+	cache := sc.writeTarget()
 
-	query := `INSERT INTO users (user_id, name, email) VALUES ($1, $2, $3) RETURNING created_at`
-	err := shard.QueryRow(query, user.UserID, user.Name, user.Email).Scan(&user.CreatedAt)
+	stmt, err := cache.Stmt(queryCreateUser)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = stmt.QueryRow(user.UserID, user.Name, user.Email).Scan(&user.CreatedAt)
 
 	if err != nil {
+		recordSQLError(shardID, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{
 		"user":       user,
-		"shard_used": user.UserID % len(sm.shards),
+		"shard_used": shardID,
 		"routing":    "application_layer",
 	})
 }
 
-// List all users from all shards (scatter-gather pattern)
-func (sm *ShardManager) listAllUsers(c *gin.Context) {
-	var allUsers []User
-
-	for i, shard := range sm.shards {
-		query := `SELECT user_id, name, email, created_at FROM users ORDER BY user_id`
-		rows, err := shard.Query(query)
-		if err != nil {
-			log.Printf("Error querying shard %d: %v", i, err)
-			continue
-		}
-		defer rows.Close()
-
-		for rows.Next() {
-			var user User
-			if err := rows.Scan(&user.UserID, &user.Name, &user.Email, &user.CreatedAt); err != nil {
-				log.Printf("Error scanning row from shard %d: %v", i, err)
-				continue
-			}
-			allUsers = append(allUsers, user)
-		}
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"users":   allUsers,
-		"count":   len(allUsers),
-		"routing": "scatter_gather_across_all_shards",
-	})
-}
-
 func main() {
 	// Initialize shard manager
 	sm, err := NewShardManager()
@@ -154,13 +266,30 @@ func main() {
 		log.Fatalf("Failed to initialize shard manager: %v", err)
 	}
 
+	// Finish any distributed transaction left in pg_prepared_xacts by a
+	// previous crash before accepting traffic - see tx_coordinator.go.
+	if err := sm.RecoverOrphanedTransactions(context.Background()); err != nil {
+		log.Printf("⚠️  startup recovery of orphaned transactions failed: %v", err)
+	}
+
 	// Setup Gin router
 	r := gin.Default()
+	r.Use(metricsMiddleware())
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	startDBStatsSampler(sm)
 
 	// Application-layer routing endpoints
 	r.GET("/user/:userID", sm.getUser)
 	r.POST("/user", sm.createUser)
-	r.GET("/users", sm.listAllUsers)
+	r.GET("/users", sm.listAllUsersPaged)
+
+	// Admin endpoints: POST adds a shard (given a dsn) if it isn't already
+	// known and kicks off a rebalance into it; DELETE drops a shard that's
+	// already had its data migrated off (see resharding.go for both).
+	r.POST("/admin/shards", sm.handleRebalance)
+	r.DELETE("/admin/shards/:shardID", sm.handleRemoveShard)
+	r.GET("/admin/stmt-cache", sm.handleStmtCacheStats)
 
 	// Health check
 	r.GET("/health", func(c *gin.Context) {
@@ -180,13 +309,13 @@ func main() {
 				"GET /users":        "List all users (scatter-gather)",
 				"GET /health":       "Health check",
 			},
-			"sharding_strategy": "hash-based (userID % num_shards)",
+			"sharding_strategy": "consistent-hash ring with virtual nodes",
 			"num_shards":        len(sm.shards),
 		})
 	})
 
 	log.Println("API Server starting on :8080")
-	log.Println("Sharding Strategy: Hash-based (userID % 2)")
+	log.Println("Sharding Strategy: Consistent hashing with virtual nodes")
 	log.Println("Endpoints available:")
 	log.Println("   - GET  /user/:userID (application-layer routing)")
 	log.Println("   - POST /user (application-layer routing)")
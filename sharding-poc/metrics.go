@@ -0,0 +1,138 @@
+package main
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// dbStatsSampleInterval is how often the background sampler below reads
+// sql.DBStats off every primary and replica connection.
+const dbStatsSampleInterval = 5 * time.Second
+
+var (
+	// httpRequestDuration is labelled by handler (the route's FullPath) and
+	// shard, so latency can be broken down per endpoint and, where a single
+	// shard served the request, per shard too. Handlers that fan out across
+	// every shard (scatter-gather) record shard="multi".
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sharding_http_request_duration_seconds",
+		Help:    "HTTP request latency, labelled by handler and shard",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"handler", "shard"})
+
+	// sqlErrorsTotal counts SQL errors labelled by the shard that produced
+	// them and a coarse error class derived from the Postgres error code (or
+	// "driver" for non-pq errors like a closed connection).
+	sqlErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sharding_sql_errors_total",
+		Help: "SQL errors, labelled by shard and error class",
+	}, []string{"shard", "class"})
+
+	// dbStat gauges mirror sql.DBStats for every primary/replica connection,
+	// labelled by shard and role so pool saturation is visible per
+	// connection rather than only in aggregate.
+	dbOpenConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sharding_db_open_connections",
+		Help: "Open connections, labelled by shard and role (primary/replica)",
+	}, []string{"shard", "role"})
+	dbInUse = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sharding_db_in_use_connections",
+		Help: "Connections currently in use, labelled by shard and role",
+	}, []string{"shard", "role"})
+	dbIdle = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sharding_db_idle_connections",
+		Help: "Idle connections, labelled by shard and role",
+	}, []string{"shard", "role"})
+	dbWaitCount = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sharding_db_wait_count",
+		Help: "Cumulative connections waited for, labelled by shard and role",
+	}, []string{"shard", "role"})
+	dbWaitDuration = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sharding_db_wait_duration_seconds",
+		Help: "Cumulative time blocked waiting for a connection, labelled by shard and role",
+	}, []string{"shard", "role"})
+)
+
+// metricsMiddleware times every request and records it under the matched
+// route's FullPath. Handlers that route to a single shard stash it in the
+// gin context (c.Set("shard", shardID)) before returning; everything else
+// falls back to shard="".
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		shard := "-"
+		if v, ok := c.Get("shard"); ok {
+			switch s := v.(type) {
+			case int:
+				shard = strconv.Itoa(s)
+			case string:
+				shard = s
+			}
+		}
+		httpRequestDuration.WithLabelValues(c.FullPath(), shard).Observe(time.Since(start).Seconds())
+	}
+}
+
+// classifySQLError buckets err into a coarse Prometheus label: the
+// Postgres error class (first two digits of the SQLSTATE code) for *pq.Error,
+// or "driver" for everything else (closed connections, context
+// cancellation, etc).
+func classifySQLError(err error) string {
+	if err == nil {
+		return ""
+	}
+	if pqErr, ok := err.(*pq.Error); ok {
+		code := string(pqErr.Code)
+		if len(code) >= 2 {
+			return code[:2] + "xxx"
+		}
+		return "pq_unknown"
+	}
+	return "driver"
+}
+
+// recordSQLError increments sqlErrorsTotal for shardID/err, skipping the
+// expected sql.ErrNoRows case since that's a normal "not found", not a
+// failure worth alerting on.
+func recordSQLError(shardID int, err error) {
+	if err == nil || err == sql.ErrNoRows {
+		return
+	}
+	sqlErrorsTotal.WithLabelValues(strconv.Itoa(shardID), classifySQLError(err)).Inc()
+}
+
+// startDBStatsSampler polls sql.DBStats for every shard's primary and
+// replicas every dbStatsSampleInterval, for the lifetime of the process.
+func startDBStatsSampler(sm *ShardManager) {
+	ticker := time.NewTicker(dbStatsSampleInterval)
+	go func() {
+		defer ticker.Stop()
+		for range ticker.C {
+			sm.mu.RLock()
+			for id, sc := range sm.shards {
+				shard := strconv.Itoa(id)
+				recordDBStats(shard, "primary", sc.primary.Stats())
+				for i, r := range sc.replicas {
+					recordDBStats(shard, "replica_"+strconv.Itoa(i), r.db.Stats())
+				}
+			}
+			sm.mu.RUnlock()
+		}
+	}()
+}
+
+func recordDBStats(shard, role string, stats sql.DBStats) {
+	dbOpenConnections.WithLabelValues(shard, role).Set(float64(stats.OpenConnections))
+	dbInUse.WithLabelValues(shard, role).Set(float64(stats.InUse))
+	dbIdle.WithLabelValues(shard, role).Set(float64(stats.Idle))
+	dbWaitCount.WithLabelValues(shard, role).Set(float64(stats.WaitCount))
+	dbWaitDuration.WithLabelValues(shard, role).Set(stats.WaitDuration.Seconds())
+}
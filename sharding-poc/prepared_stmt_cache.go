@@ -0,0 +1,109 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Named queries routed through the PreparedStatementCache. Keeping them as
+// constants means every call site prepares (and caches) under the same
+// stable name instead of accidentally creating duplicate statements.
+const (
+	queryGetUser       = "get_user"
+	queryCreateUser    = "create_user"
+	queryListShardPage = "list_shard_page"
+)
+
+var stmtText = map[string]string{
+	queryGetUser:       `SELECT user_id, name, email, created_at FROM users WHERE user_id = $1`,
+	queryCreateUser:    `INSERT INTO users (user_id, name, email) VALUES ($1, $2, $3) RETURNING created_at`,
+	queryListShardPage: `SELECT user_id, name, email, created_at FROM users WHERE user_id > $1 ORDER BY user_id LIMIT $2`,
+}
+
+// PreparedStatementCache lazily prepares named queries against a *sql.DB and
+// reuses the resulting *sql.Stmt across requests, so PostgreSQL only has to
+// parse and plan each query once instead of on every call. It's keyed by a
+// stable query name (see the query* constants) rather than the raw SQL text.
+type PreparedStatementCache struct {
+	db    *sql.DB
+	stmts sync.Map // query name -> *sql.Stmt
+
+	hits   uint64
+	misses uint64
+}
+
+// NewPreparedStatementCache wraps db with a per-connection statement cache.
+func NewPreparedStatementCache(db *sql.DB) *PreparedStatementCache {
+	return &PreparedStatementCache{db: db}
+}
+
+// Stmt returns the prepared statement for name, preparing and caching it on
+// first use. If a cached statement has gone stale (e.g. the underlying
+// connection was reset), callers get database/sql's own transparent retry
+// since *sql.Stmt re-prepares itself against a fresh connection as needed.
+func (c *PreparedStatementCache) Stmt(name string) (*sql.Stmt, error) {
+	if cached, ok := c.stmts.Load(name); ok {
+		atomic.AddUint64(&c.hits, 1)
+		return cached.(*sql.Stmt), nil
+	}
+
+	query, ok := stmtText[name]
+	if !ok {
+		return nil, sql.ErrNoRows // unknown query name; shouldn't happen with the constants above
+	}
+
+	stmt, err := c.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Another goroutine may have prepared the same statement concurrently;
+	// keep whichever one won the race and close the loser to avoid leaking
+	// a prepared statement on the connection.
+	actual, loaded := c.stmts.LoadOrStore(name, stmt)
+	if loaded {
+		stmt.Close()
+		atomic.AddUint64(&c.hits, 1)
+		return actual.(*sql.Stmt), nil
+	}
+
+	atomic.AddUint64(&c.misses, 1)
+	return stmt, nil
+}
+
+// Stats reports cache hit/miss counters for the /metrics endpoint.
+func (c *PreparedStatementCache) Stats() (hits, misses uint64) {
+	return atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses)
+}
+
+// handleStmtCacheStats exposes per-shard prepared-statement cache hit/miss
+// counts so operators can see how effective the cache is under load.
+func (sm *ShardManager) handleStmtCacheStats(c *gin.Context) {
+	sm.mu.RLock()
+	conns := make(map[int]*ShardConn, len(sm.shards))
+	for id, sc := range sm.shards {
+		conns[id] = sc
+	}
+	sm.mu.RUnlock()
+
+	perShard := make(map[string]gin.H, len(conns))
+	for id, sc := range conns {
+		primaryHits, primaryMisses := sc.primaryCache.Stats()
+		replicas := make([]gin.H, len(sc.replicas))
+		for i, r := range sc.replicas {
+			hits, misses := r.cache.Stats()
+			replicas[i] = gin.H{"healthy": r.isHealthy(), "hits": hits, "misses": misses}
+		}
+		perShard[strconv.Itoa(id)] = gin.H{
+			"primary":  gin.H{"hits": primaryHits, "misses": primaryMisses},
+			"replicas": replicas,
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"shards": perShard})
+}
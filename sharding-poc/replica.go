@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReplicaPolicy selects which healthy replica serves a given read.
+type ReplicaPolicy string
+
+const (
+	RoundRobinPolicy     ReplicaPolicy = "round_robin"
+	LeastLatencyPolicy   ReplicaPolicy = "least_latency"
+	RandomPolicy         ReplicaPolicy = "random"
+	defaultReplicaPolicy               = RoundRobinPolicy
+)
+
+const (
+	// healthCheckInterval is how often each replica gets pinged.
+	healthCheckInterval = 5 * time.Second
+	// healthCheckTimeout bounds each individual ping.
+	healthCheckTimeout = 2 * time.Second
+	// unhealthyAfter/healthyAfter are the consecutive-failure/success
+	// thresholds before a replica flips state, so a single flaky ping
+	// doesn't yank it in and out of rotation.
+	unhealthyAfter = 3
+	healthyAfter   = 3
+)
+
+// Replica wraps one read replica connection with the health-tracking state
+// needed for failover: consecutive failure/success counts and the last
+// observed ping latency (used by LeastLatencyPolicy).
+type Replica struct {
+	db    *sql.DB
+	cache *PreparedStatementCache
+
+	mu                   sync.RWMutex
+	healthy              bool
+	consecutiveFailures  int
+	consecutiveSuccesses int
+	lastLatency          time.Duration
+
+	cancel context.CancelFunc
+}
+
+func newReplica(db *sql.DB) *Replica {
+	return &Replica{db: db, cache: NewPreparedStatementCache(db), healthy: true}
+}
+
+// startHealthChecks runs a ticker+context-cancelled goroutine that pings
+// the replica every healthCheckInterval and flips its health flag after
+// unhealthyAfter/healthyAfter consecutive pings in the same direction.
+func (r *Replica) startHealthChecks(parent context.Context) {
+	ctx, cancel := context.WithCancel(parent)
+	r.cancel = cancel
+
+	go func() {
+		ticker := time.NewTicker(healthCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.ping(ctx)
+			}
+		}
+	}()
+}
+
+func (r *Replica) ping(parent context.Context) {
+	ctx, cancel := context.WithTimeout(parent, healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := r.db.PingContext(ctx)
+	latency := time.Since(start)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastLatency = latency
+	if err != nil {
+		r.consecutiveFailures++
+		r.consecutiveSuccesses = 0
+		if r.healthy && r.consecutiveFailures >= unhealthyAfter {
+			r.healthy = false
+			log.Printf("⚠️  replica marked unhealthy after %d consecutive failures: %v", r.consecutiveFailures, err)
+		}
+		return
+	}
+
+	r.consecutiveSuccesses++
+	r.consecutiveFailures = 0
+	if !r.healthy && r.consecutiveSuccesses >= healthyAfter {
+		r.healthy = true
+		log.Printf("✅ replica marked healthy again after %d consecutive successes", r.consecutiveSuccesses)
+	}
+}
+
+func (r *Replica) isHealthy() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.healthy
+}
+
+func (r *Replica) latency() time.Duration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.lastLatency
+}
+
+func (r *Replica) stop() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+}
+
+// ShardConn is one logical shard's connection set: a single primary (used
+// for all writes and strong-consistency reads) plus zero or more read
+// replicas selected by policy for ordinary reads.
+type ShardConn struct {
+	primary      *sql.DB
+	primaryCache *PreparedStatementCache
+	replicas     []*Replica
+	policy       ReplicaPolicy
+
+	rrCounter uint64 // round-robin cursor, advanced atomically
+}
+
+// newShardConn dials the primary and every replica DSN for one shard and
+// starts a health checker for each replica.
+func newShardConn(ctx context.Context, primaryDSN string, replicaDSNs []string, policy ReplicaPolicy) (*ShardConn, error) {
+	primary, err := sql.Open("postgres", primaryDSN)
+	if err != nil {
+		return nil, err
+	}
+	if err := primary.Ping(); err != nil {
+		return nil, err
+	}
+
+	sc := &ShardConn{
+		primary:      primary,
+		primaryCache: NewPreparedStatementCache(primary),
+		policy:       policy,
+	}
+
+	for _, dsn := range replicaDSNs {
+		dsn = strings.TrimSpace(dsn)
+		if dsn == "" {
+			continue
+		}
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.Ping(); err != nil {
+			return nil, err
+		}
+		replica := newReplica(db)
+		replica.startHealthChecks(ctx)
+		sc.replicas = append(sc.replicas, replica)
+	}
+
+	return sc, nil
+}
+
+// readTarget returns the cache to use for a read: a healthy replica chosen
+// per sc.policy, or the primary if no replica is healthy (or none exist).
+func (sc *ShardConn) readTarget() *PreparedStatementCache {
+	healthy := make([]*Replica, 0, len(sc.replicas))
+	for _, r := range sc.replicas {
+		if r.isHealthy() {
+			healthy = append(healthy, r)
+		}
+	}
+	if len(healthy) == 0 {
+		return sc.primaryCache
+	}
+
+	switch sc.policy {
+	case LeastLatencyPolicy:
+		best := healthy[0]
+		for _, r := range healthy[1:] {
+			if r.latency() < best.latency() {
+				best = r
+			}
+		}
+		return best.cache
+
+	case RandomPolicy:
+		return healthy[rand.Intn(len(healthy))].cache
+
+	default: // RoundRobinPolicy
+		i := atomic.AddUint64(&sc.rrCounter, 1)
+		return healthy[int(i)%len(healthy)].cache
+	}
+}
+
+// writeTarget always returns the primary's cache - writes and
+// read-your-writes reads never go to a replica.
+func (sc *ShardConn) writeTarget() *PreparedStatementCache {
+	return sc.primaryCache
+}
+
+func (sc *ShardConn) close() {
+	sc.primary.Close()
+	for _, r := range sc.replicas {
+		r.stop()
+		r.db.Close()
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// reshardBatchSize caps how many rows we move per round trip while
+// streaming rows off a source shard during a rebalance.
+const reshardBatchSize = 500
+
+// defaultShardWeight is used for AddShard calls that don't specify one -
+// router.go treats weight as a multiplier on defaultVnodesPerShard, so 1 is
+// "normal" weight, same as every shard NewShardManager wires in at startup.
+const defaultShardWeight = 1
+
+// RebalanceRequest is the body accepted by POST /admin/shards. If
+// NewShardID isn't already known to the ShardManager, DSN is required and
+// the shard is wired in (sm.AddShard) before the rebalance is triggered;
+// if it's already known, DSN/Weight are ignored.
+type RebalanceRequest struct {
+	NewShardID int    `json:"new_shard_id" binding:"required"`
+	DSN        string `json:"dsn,omitempty"`
+	Weight     int    `json:"weight,omitempty"`
+}
+
+// handleRebalance wires a new shard into the ShardManager if it isn't
+// already known (given a DSN), then kicks off a background resharding
+// worker that streams rows whose new hash lands on NewShardID away from
+// every other shard. It returns as soon as the shard is ready to receive
+// writes; resharding progress is only observable via logs today.
+func (sm *ShardManager) handleRebalance(c *gin.Context) {
+	var req RebalanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, ok := sm.shardByID(req.NewShardID); !ok {
+		if req.DSN == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "unknown shard; provide dsn to add it as a new shard"})
+			return
+		}
+
+		weight := req.Weight
+		if weight <= 0 {
+			weight = defaultShardWeight
+		}
+
+		db, err := sql.Open("postgres", req.DSN)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("failed to open dsn: %v", err)})
+			return
+		}
+		if err := db.Ping(); err != nil {
+			db.Close()
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("failed to connect to new shard: %v", err)})
+			return
+		}
+
+		sm.AddShard(req.NewShardID, db, weight)
+		log.Printf("✅ added shard %d at runtime (weight=%d)", req.NewShardID, weight)
+	}
+
+	go sm.reshardInto(req.NewShardID)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":      "resharding started",
+		"new_shard_id": req.NewShardID,
+	})
+}
+
+// handleRemoveShard drops a shard from the ShardManager at runtime. Callers
+// are responsible for having moved its data off first (e.g. by adding
+// another shard and letting the ring's resharding reassign its keyspace
+// away from it first).
+func (sm *ShardManager) handleRemoveShard(c *gin.Context) {
+	shardID, err := strconv.Atoi(c.Param("shardID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid shard id"})
+		return
+	}
+
+	if _, ok := sm.shardByID(shardID); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown shard"})
+		return
+	}
+
+	sm.RemoveShard(shardID)
+	log.Printf("✅ removed shard %d at runtime", shardID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "shard removed", "shard_id": shardID})
+}
+
+// reshardInto moves every row that now hashes to newShardID off of its
+// previous owner. Because the ring only reassigns ~1/N of the keyspace to a
+// joining shard, this touches a small fraction of rows compared to a full
+// re-shuffle under modulo hashing.
+func (sm *ShardManager) reshardInto(newShardID int) {
+	if _, ok := sm.shardByID(newShardID); !ok {
+		log.Printf("❌ resharding: unknown shard %d", newShardID)
+		return
+	}
+
+	for _, sourceID := range sm.shardIDs() {
+		if sourceID == newShardID {
+			continue
+		}
+		sm.streamMisplacedRows(sourceID, newShardID)
+	}
+
+	log.Printf("✅ resharding into shard %d complete", newShardID)
+}
+
+// streamMisplacedRows scans sourceID's users table, finds the rows whose
+// current route now lands on newShardID, and moves them over in batches of
+// reshardBatchSize.
+func (sm *ShardManager) streamMisplacedRows(sourceID, newShardID int) {
+	src, ok := sm.shardByID(sourceID)
+	if !ok {
+		return
+	}
+
+	rows, err := src.Query(`SELECT user_id FROM users ORDER BY user_id`)
+	if err != nil {
+		log.Printf("❌ resharding: failed to scan shard %d: %v", sourceID, err)
+		return
+	}
+	defer rows.Close()
+
+	var movedIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			continue
+		}
+		want, err := sm.router.ShardFor(userID)
+		if err != nil {
+			log.Printf("❌ resharding: %v", err)
+			return
+		}
+		if want == newShardID {
+			movedIDs = append(movedIDs, userID)
+		}
+		if len(movedIDs) >= reshardBatchSize {
+			sm.moveBatch(sourceID, newShardID, movedIDs)
+			movedIDs = nil
+		}
+	}
+	if len(movedIDs) > 0 {
+		sm.moveBatch(sourceID, newShardID, movedIDs)
+	}
+}
+
+// moveBatch copies the given user IDs from sourceID to newShardID using a
+// single `WHERE user_id = ANY($1)` select, then deletes them from the
+// source. Errors are logged rather than retried; a production version would
+// wrap both sides in a two-phase commit (see tx_coordinator.go).
+func (sm *ShardManager) moveBatch(sourceID, newShardID int, userIDs []int) {
+	if len(userIDs) == 0 {
+		return
+	}
+	src, ok := sm.shardByID(sourceID)
+	if !ok {
+		return
+	}
+	dst, ok := sm.shardByID(newShardID)
+	if !ok {
+		return
+	}
+
+	rows, err := src.Query(
+		`SELECT user_id, name, email, created_at FROM users WHERE user_id = ANY($1)`,
+		pq.Array(userIDs),
+	)
+	if err != nil {
+		log.Printf("❌ resharding: select batch from shard %d failed: %v", sourceID, err)
+		return
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.UserID, &u.Name, &u.Email, &u.CreatedAt); err != nil {
+			continue
+		}
+		users = append(users, u)
+	}
+
+	for _, u := range users {
+		if _, err := dst.Exec(
+			`INSERT INTO users (user_id, name, email, created_at) VALUES ($1, $2, $3, $4)
+			 ON CONFLICT (user_id) DO NOTHING`,
+			u.UserID, u.Name, u.Email, u.CreatedAt,
+		); err != nil {
+			log.Printf("❌ resharding: insert into shard %d failed for user %d: %v", newShardID, u.UserID, err)
+			continue
+		}
+		if _, err := src.Exec(`DELETE FROM users WHERE user_id = $1`, u.UserID); err != nil {
+			log.Printf("❌ resharding: cleanup delete on shard %d failed for user %d: %v", sourceID, u.UserID, err)
+		}
+	}
+
+	log.Printf("🔀 resharding: moved %d rows from shard %d to shard %d", len(users), sourceID, newShardID)
+}
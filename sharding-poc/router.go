@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// errNoShards is returned by ShardFor when every shard has been removed
+// (e.g. via DELETE /admin/shards/:shardID), instead of the router
+// panicking on an empty ring/shard list.
+var errNoShards = errors.New("no shards registered with the router")
+
+// ShardRouter maps a userID to the shard index that owns it. Implementations
+// must be safe for concurrent use since the HTTP handlers call them from
+// multiple goroutines.
+type ShardRouter interface {
+	// ShardFor returns the index into ShardManager.shards that owns userID,
+	// or errNoShards if no shard is currently registered.
+	ShardFor(userID int) (int, error)
+	// AddShard registers a new physical shard with the router. weight only
+	// matters to routers that support weighted distribution (e.g. consistent
+	// hashing); others may ignore it.
+	AddShard(id int, weight int)
+	// RemoveShard unregisters a physical shard from the router.
+	RemoveShard(id int)
+}
+
+// ModuloRouter is the original userID % numShards scheme, kept around as the
+// default for small/simple deployments and for parity testing against the
+// consistent-hash router.
+type ModuloRouter struct {
+	mu     sync.RWMutex
+	shards []int
+}
+
+// NewModuloRouter builds a ModuloRouter over the given shard IDs.
+func NewModuloRouter(shardIDs []int) *ModuloRouter {
+	shards := make([]int, len(shardIDs))
+	copy(shards, shardIDs)
+	return &ModuloRouter{shards: shards}
+}
+
+func (r *ModuloRouter) ShardFor(userID int) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.shards) == 0 {
+		return 0, errNoShards
+	}
+	return r.shards[userID%len(r.shards)], nil
+}
+
+func (r *ModuloRouter) AddShard(id int, weight int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.shards = append(r.shards, id)
+}
+
+func (r *ModuloRouter) RemoveShard(id int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, s := range r.shards {
+		if s == id {
+			r.shards = append(r.shards[:i], r.shards[i+1:]...)
+			return
+		}
+	}
+}
+
+// defaultVnodesPerShard is the number of virtual nodes placed on the ring per
+// unit of weight. 150 gives a reasonably even key distribution without
+// bloating the ring for a handful of shards.
+const defaultVnodesPerShard = 150
+
+// ConsistentHashRouter routes users to shards using consistent hashing with
+// virtual nodes, so that adding or removing a shard only moves roughly 1/N
+// of the keyspace instead of reshuffling almost everything the way modulo
+// hashing does.
+type ConsistentHashRouter struct {
+	mu        sync.RWMutex
+	vnodes    int
+	ring      []uint64       // sorted vnode hashes
+	ringShard map[uint64]int // vnode hash -> shard id
+	weights   map[int]int    // shard id -> weight
+}
+
+// NewConsistentHashRouter builds a ring seeded with the given shard IDs, each
+// at the default weight (1 unit = defaultVnodesPerShard virtual nodes).
+func NewConsistentHashRouter(shardIDs []int) *ConsistentHashRouter {
+	r := &ConsistentHashRouter{
+		vnodes:    defaultVnodesPerShard,
+		ringShard: make(map[uint64]int),
+		weights:   make(map[int]int),
+	}
+	for _, id := range shardIDs {
+		r.addShardLocked(id, 1)
+	}
+	return r
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum64()
+}
+
+func vnodeKey(shardID, vnode int) string {
+	return strconv.Itoa(shardID) + "#" + strconv.Itoa(vnode)
+}
+
+// addShardLocked rebuilds the ring to include weight * vnodes virtual nodes
+// for shardID. Callers must hold r.mu.
+func (r *ConsistentHashRouter) addShardLocked(shardID int, weight int) {
+	if weight <= 0 {
+		weight = 1
+	}
+	r.weights[shardID] = weight
+	for v := 0; v < r.vnodes*weight; v++ {
+		h := hashKey(vnodeKey(shardID, v))
+		r.ringShard[h] = shardID
+	}
+	r.rebuildRingLocked()
+}
+
+func (r *ConsistentHashRouter) rebuildRingLocked() {
+	ring := make([]uint64, 0, len(r.ringShard))
+	for h := range r.ringShard {
+		ring = append(ring, h)
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i] < ring[j] })
+	r.ring = ring
+}
+
+// AddShard adds shardID to the ring with weight*vnodesPerShard virtual
+// nodes and rebalances under the write lock so concurrent lookups never see
+// a half-built ring.
+func (r *ConsistentHashRouter) AddShard(shardID int, weight int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.addShardLocked(shardID, weight)
+}
+
+// RemoveShard strips every virtual node belonging to shardID from the ring.
+func (r *ConsistentHashRouter) RemoveShard(shardID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	weight := r.weights[shardID]
+	delete(r.weights, shardID)
+	for v := 0; v < r.vnodes*weight; v++ {
+		delete(r.ringShard, hashKey(vnodeKey(shardID, v)))
+	}
+	r.rebuildRingLocked()
+}
+
+// ShardFor hashes userID onto the ring and walks clockwise to the first
+// virtual node, using sort.Search for an O(log N) lookup.
+func (r *ConsistentHashRouter) ShardFor(userID int) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.ring) == 0 {
+		return 0, errNoShards
+	}
+
+	h := hashKey(strconv.Itoa(userID))
+	idx := sort.Search(len(r.ring), func(i int) bool { return r.ring[i] >= h })
+	if idx == len(r.ring) {
+		idx = 0 // wrap around the ring
+	}
+	return r.ringShard[r.ring[idx]], nil
+}
+
+// RangeRouter assigns contiguous userID ranges to shards, in ascending order
+// of the boundaries provided. It's useful when downstream range scans (e.g.
+// "all users created this week") should stay within a single shard.
+type RangeRouter struct {
+	mu sync.RWMutex
+	// bounds[i] is the inclusive upper bound of shards[i]'s range; the last
+	// shard absorbs everything above bounds[len(bounds)-2].
+	bounds []int
+	shards []int
+}
+
+// NewRangeRouter builds a router from shard IDs and the upper bound of every
+// shard except the last, which takes everything above the final bound.
+func NewRangeRouter(shardIDs []int, upperBounds []int) *RangeRouter {
+	shards := make([]int, len(shardIDs))
+	copy(shards, shardIDs)
+	bounds := make([]int, len(upperBounds))
+	copy(bounds, upperBounds)
+	return &RangeRouter{shards: shards, bounds: bounds}
+}
+
+func (r *RangeRouter) ShardFor(userID int) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.shards) == 0 {
+		return 0, errNoShards
+	}
+
+	for i, bound := range r.bounds {
+		if userID <= bound {
+			return r.shards[i], nil
+		}
+	}
+	return r.shards[len(r.shards)-1], nil
+}
+
+func (r *RangeRouter) AddShard(id int, weight int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.shards = append(r.shards, id)
+}
+
+func (r *RangeRouter) RemoveShard(id int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, s := range r.shards {
+		if s == id {
+			r.shards = append(r.shards[:i], r.shards[i+1:]...)
+			if i < len(r.bounds) {
+				r.bounds = append(r.bounds[:i], r.bounds[i+1:]...)
+			}
+			return
+		}
+	}
+}
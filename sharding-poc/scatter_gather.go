@@ -0,0 +1,230 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FailurePolicy controls how ScatterGather reacts when one or more shards
+// error out or time out mid-query.
+type FailurePolicy string
+
+const (
+	// FailFast aborts and returns an error as soon as any shard fails.
+	FailFast FailurePolicy = "fail_fast"
+	// BestEffort returns whatever rows the healthy shards produced, marking
+	// the response partial and listing which shards failed.
+	BestEffort FailurePolicy = "best_effort"
+	// RequireQuorum succeeds only if a strict majority of shards responded;
+	// otherwise it behaves like FailFast.
+	RequireQuorum FailurePolicy = "require_quorum"
+)
+
+// scatterTimeout bounds how long we wait on any single shard per page.
+const scatterTimeout = 3 * time.Second
+
+// scatterWorkerLimit bounds how many shards are queried concurrently. With
+// only a couple of shards today this is mostly future-proofing for
+// clusters with many more.
+const scatterWorkerLimit = 8
+
+// shardCursor is one shard's contribution to the k-way merge: a page of
+// rows already sorted by user_id, plus the index of the next unread row.
+type shardCursor struct {
+	shardID int
+	rows    []User
+	pos     int
+}
+
+func (c *shardCursor) peek() (User, bool) {
+	if c.pos >= len(c.rows) {
+		return User{}, false
+	}
+	return c.rows[c.pos], true
+}
+
+// cursorHeap is a min-heap over shardCursors ordered by the next unread
+// row's user_id, letting ScatterGather produce a globally-sorted stream
+// without loading every shard's full result set into memory at once.
+type cursorHeap []*shardCursor
+
+func (h cursorHeap) Len() int { return len(h) }
+func (h cursorHeap) Less(i, j int) bool {
+	ui, _ := h[i].peek()
+	uj, _ := h[j].peek()
+	return ui.UserID < uj.UserID
+}
+func (h cursorHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *cursorHeap) Push(x interface{}) { *h = append(*h, x.(*shardCursor)) }
+func (h *cursorHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// ScatterGatherResult is the response shape for a fanned-out, merged query.
+type ScatterGatherResult struct {
+	Users        []User `json:"users"`
+	Partial      bool   `json:"partial"`
+	FailedShards []int  `json:"failed_shards,omitempty"`
+	NextAfter    *int   `json:"next_after,omitempty"`
+}
+
+// shardPage fetches one page of rows from a single shard, filtered by the
+// cursor and ordered by user_id so it can feed the heap merge. It goes
+// through the shard's PreparedStatementCache so repeated pages don't force
+// PostgreSQL to re-parse the query on every call.
+func fetchShardPage(ctx context.Context, cache *PreparedStatementCache, after, limit int) ([]User, error) {
+	stmt, err := cache.Stmt(queryListShardPage)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.QueryContext(ctx, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var page []User
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.UserID, &u.Name, &u.Email, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		page = append(page, u)
+	}
+	return page, rows.Err()
+}
+
+// scatterGather fans the same page request out to every shard concurrently
+// (bounded by scatterWorkerLimit), then merges the per-shard pages with a
+// k-way heap merge so the combined result stays sorted by user_id.
+func (sm *ShardManager) scatterGather(ctx context.Context, after, limit int, policy FailurePolicy, strongConsistency bool) ScatterGatherResult {
+	sm.mu.RLock()
+	caches := make(map[int]*PreparedStatementCache, len(sm.shards))
+	for id, sc := range sm.shards {
+		if strongConsistency {
+			caches[id] = sc.writeTarget()
+		} else {
+			caches[id] = sc.readTarget()
+		}
+	}
+	sm.mu.RUnlock()
+
+	type shardResult struct {
+		shardID int
+		rows    []User
+		err     error
+	}
+
+	sem := make(chan struct{}, scatterWorkerLimit)
+	resultCh := make(chan shardResult, len(caches))
+	var wg sync.WaitGroup
+
+	for shardID, cache := range caches {
+		wg.Add(1)
+		go func(shardID int, cache *PreparedStatementCache) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			shardCtx, cancel := context.WithTimeout(ctx, scatterTimeout)
+			defer cancel()
+
+			rows, err := fetchShardPage(shardCtx, cache, after, limit)
+			resultCh <- shardResult{shardID: shardID, rows: rows, err: err}
+		}(shardID, cache)
+	}
+
+	wg.Wait()
+	close(resultCh)
+
+	var cursors cursorHeap
+	var failedShards []int
+	for res := range resultCh {
+		if res.err != nil {
+			log.Printf("❌ scatter-gather: shard %d failed: %v", res.shardID, res.err)
+			recordSQLError(res.shardID, res.err)
+			failedShards = append(failedShards, res.shardID)
+			continue
+		}
+		if len(res.rows) > 0 {
+			cursors = append(cursors, &shardCursor{shardID: res.shardID, rows: res.rows})
+		}
+	}
+
+	partial := len(failedShards) > 0
+	if partial && policy == FailFast {
+		return ScatterGatherResult{Partial: true, FailedShards: failedShards}
+	}
+	if partial && policy == RequireQuorum && len(failedShards)*2 >= len(caches) {
+		return ScatterGatherResult{Partial: true, FailedShards: failedShards}
+	}
+
+	heap.Init(&cursors)
+
+	merged := make([]User, 0, limit)
+	for cursors.Len() > 0 && len(merged) < limit {
+		top := cursors[0]
+		u, ok := top.peek()
+		if !ok {
+			heap.Pop(&cursors)
+			continue
+		}
+		merged = append(merged, u)
+		top.pos++
+		heap.Fix(&cursors, 0)
+	}
+
+	result := ScatterGatherResult{
+		Users:        merged,
+		Partial:      partial,
+		FailedShards: failedShards,
+	}
+	if len(merged) == limit {
+		next := merged[len(merged)-1].UserID
+		result.NextAfter = &next
+	}
+	return result
+}
+
+// listAllUsersPaged replaces the old sequential, error-swallowing
+// implementation with the bounded, concurrent ScatterGather subsystem. It
+// supports ?after=<user_id>&limit=N cursor pagination and
+// ?policy=fail_fast|best_effort|require_quorum.
+func (sm *ShardManager) listAllUsersPaged(c *gin.Context) {
+	c.Set("shard", "multi")
+	after, _ := strconv.Atoi(c.DefaultQuery("after", "0"))
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+
+	policy := FailurePolicy(c.DefaultQuery("policy", string(BestEffort)))
+	switch policy {
+	case FailFast, BestEffort, RequireQuorum:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid policy, expected fail_fast|best_effort|require_quorum"})
+		return
+	}
+
+	strongConsistency := c.Query("consistency") == "strong"
+	result := sm.scatterGather(c.Request.Context(), after, limit, policy, strongConsistency)
+
+	if result.Partial && policy != BestEffort {
+		c.JSON(http.StatusBadGateway, result)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// txLogTable lives on the designated coordinator shard and durably records
+// every distributed transaction's global ID and participant list before the
+// commit phase starts, so a crash between PREPARE and COMMIT PREPARED can be
+// recovered from.
+const txLogTable = `
+CREATE TABLE IF NOT EXISTS shard_tx_log (
+	gid         TEXT PRIMARY KEY,
+	participants TEXT NOT NULL, -- comma-separated shard IDs
+	status      TEXT NOT NULL DEFAULT 'preparing', -- preparing | committing | committed | rolled_back
+	created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+)`
+
+// ShardTxCoordinator runs distributed writes across multiple shards using
+// PostgreSQL's two-phase commit (PREPARE TRANSACTION / COMMIT PREPARED),
+// so a multi-entity write (e.g. a user plus their profile on a different
+// shard) either lands everywhere or nowhere.
+type ShardTxCoordinator struct {
+	sm                 *ShardManager
+	coordinatorShardID int
+}
+
+// NewShardTxCoordinator builds a coordinator that logs transaction state on
+// coordinatorShardID. It must be one of sm's existing shards.
+func NewShardTxCoordinator(sm *ShardManager, coordinatorShardID int) (*ShardTxCoordinator, error) {
+	db, ok := sm.shardByID(coordinatorShardID)
+	if !ok {
+		return nil, fmt.Errorf("coordinator shard %d is not known to the ShardManager", coordinatorShardID)
+	}
+	if _, err := db.Exec(txLogTable); err != nil {
+		return nil, fmt.Errorf("failed to create shard_tx_log: %w", err)
+	}
+	return &ShardTxCoordinator{sm: sm, coordinatorShardID: coordinatorShardID}, nil
+}
+
+// ShardTx is the handle passed into the closure given to WithinTx. Callers
+// use OnShard to get a *sql.Tx-backed handle for whichever shard a given
+// entity lives on; the coordinator opens the underlying BEGIN lazily and
+// only once per shard per transaction.
+type ShardTx struct {
+	coord *ShardTxCoordinator
+	gid   string
+	txs   map[int]*sql.Tx
+	// err latches the first error hit while opening a per-shard
+	// transaction via OnShard, since OnShard's signature (to support
+	// tx.OnShard(userID).Exec(...) chaining) has no room for one.
+	err error
+}
+
+// OnShard routes userID through the ShardManager's router and returns the
+// *sql.Tx for the shard it lands on, opening it with BEGIN the first time
+// it's requested within this distributed transaction. Two different
+// userIDs that hash to the same shard share a single underlying *sql.Tx.
+func (tx *ShardTx) OnShard(userID int) *sql.Tx {
+	shardID, err := tx.coord.sm.router.ShardFor(userID)
+	if err != nil {
+		tx.err = err
+		return nil
+	}
+
+	if existing, ok := tx.txs[shardID]; ok {
+		return existing
+	}
+
+	db, ok := tx.coord.sm.shardByID(shardID)
+	if !ok {
+		tx.err = fmt.Errorf("unknown shard %d for user %d", shardID, userID)
+		return nil
+	}
+
+	sqlTx, err := db.Begin()
+	if err != nil {
+		tx.err = fmt.Errorf("BEGIN on shard %d failed: %w", shardID, err)
+		return nil
+	}
+	tx.txs[shardID] = sqlTx
+	return sqlTx
+}
+
+func newGID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "gid-" + hex.EncodeToString(buf), nil
+}
+
+// WithinTx runs fn against a fresh distributed transaction: every shard fn
+// touches via tx.OnShard gets PREPARE TRANSACTION'd, the participant list is
+// durably logged on the coordinator shard, and only once every shard has
+// prepared successfully do we COMMIT PREPARED everywhere. Any failure rolls
+// every prepared (or still-open) shard transaction back.
+func (c *ShardTxCoordinator) WithinTx(ctx context.Context, fn func(tx *ShardTx) error) error {
+	gid, err := newGID()
+	if err != nil {
+		return fmt.Errorf("failed to generate transaction id: %w", err)
+	}
+
+	tx := &ShardTx{coord: c, gid: gid, txs: make(map[int]*sql.Tx)}
+
+	if err := fn(tx); err != nil {
+		releaseConns(tx.txs)
+		return err
+	}
+	if tx.err != nil {
+		releaseConns(tx.txs)
+		return tx.err
+	}
+
+	if err := c.logParticipants(ctx, gid, tx.txs); err != nil {
+		releaseConns(tx.txs)
+		return fmt.Errorf("failed to durably log transaction %s: %w", gid, err)
+	}
+
+	prepared, prepareErr := preparePhase(tx)
+	// Every shard's sqlTx is done being used the moment preparePhase returns:
+	// shards in `prepared` had PREPARE TRANSACTION detach the transaction
+	// from its connection, and whatever didn't prepare just needs an
+	// ordinary rollback of its still-open BEGIN. Release all of them now so
+	// the connections go back to the pool regardless of which path below we
+	// take - commitPrepared/rollbackPrepared talk to Postgres over fresh
+	// connections via *sql.DB, not these.
+	releaseConns(tx.txs)
+
+	if prepareErr != nil {
+		rollbackPrepared(c.sm, gid, prepared)
+		c.markTxLog(ctx, gid, "rolled_back")
+		return fmt.Errorf("prepare phase failed for %s: %w", gid, prepareErr)
+	}
+
+	// Record the decision to commit *before* issuing any COMMIT PREPARED, so
+	// a crash partway through commitPrepared leaves a durable trail that
+	// distinguishes "decided to commit, some participants may already have
+	// committed" from "never finished preparing" - see
+	// RecoverOrphanedTransactions for why that distinction matters.
+	c.markTxLog(ctx, gid, "committing")
+
+	if err := commitPrepared(c.sm, gid, prepared); err != nil {
+		// Prepared transactions already survive a crash in pg_prepared_xacts;
+		// the recovery worker will finish the commit on restart.
+		log.Printf("⚠️  commit phase failed for %s, leaving prepared for recovery: %v", gid, err)
+		return fmt.Errorf("commit phase failed for %s (will be completed by recovery worker): %w", gid, err)
+	}
+
+	c.markTxLog(ctx, gid, "committed")
+	return nil
+}
+
+func (c *ShardTxCoordinator) logParticipants(ctx context.Context, gid string, txs map[int]*sql.Tx) error {
+	db, _ := c.sm.shardByID(c.coordinatorShardID)
+
+	ids := make([]byte, 0, len(txs)*2)
+	first := true
+	for shardID := range txs {
+		if !first {
+			ids = append(ids, ',')
+		}
+		ids = append(ids, []byte(fmt.Sprintf("%d", shardID))...)
+		first = false
+	}
+
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO shard_tx_log (gid, participants, status) VALUES ($1, $2, 'preparing')`,
+		gid, string(ids),
+	)
+	return err
+}
+
+func (c *ShardTxCoordinator) markTxLog(ctx context.Context, gid, status string) {
+	db, ok := c.sm.shardByID(c.coordinatorShardID)
+	if !ok {
+		return
+	}
+	if _, err := db.ExecContext(ctx, `UPDATE shard_tx_log SET status = $1 WHERE gid = $2`, status, gid); err != nil {
+		log.Printf("⚠️  failed to update shard_tx_log status for %s: %v", gid, err)
+	}
+}
+
+// preparePhase issues PREPARE TRANSACTION on every participating shard,
+// returning the shard IDs that succeeded before any failure (possibly a
+// strict subset of tx.txs, since map iteration order is random). The caller
+// is responsible for rolling the rest back.
+func preparePhase(tx *ShardTx) ([]int, error) {
+	prepared := make([]int, 0, len(tx.txs))
+	for shardID, sqlTx := range tx.txs {
+		if _, err := sqlTx.Exec(fmt.Sprintf("PREPARE TRANSACTION '%s'", tx.gid)); err != nil {
+			return prepared, fmt.Errorf("shard %d: %w", shardID, err)
+		}
+		prepared = append(prepared, shardID)
+	}
+	return prepared, nil
+}
+
+// commitPrepared issues COMMIT PREPARED against every shard in shardIDs
+// using a fresh connection, since the *sql.Tx that issued PREPARE
+// TRANSACTION is no longer usable once the transaction has been handed off
+// to PostgreSQL's two-phase commit machinery.
+func commitPrepared(sm *ShardManager, gid string, shardIDs []int) error {
+	for _, shardID := range shardIDs {
+		db, ok := sm.shardByID(shardID)
+		if !ok {
+			return fmt.Errorf("shard %d disappeared before commit", shardID)
+		}
+		if _, err := db.Exec(fmt.Sprintf("COMMIT PREPARED '%s'", gid)); err != nil {
+			return fmt.Errorf("shard %d: %w", shardID, err)
+		}
+	}
+	return nil
+}
+
+// releaseConns returns every shard's pooled connection back to
+// database/sql by issuing Rollback() on each sqlTx. For a shard that never
+// reached PREPARE TRANSACTION this is an ordinary rollback of the open
+// BEGIN; for a shard that did, PREPARE TRANSACTION already detached the
+// transaction from this connection, so the Rollback() here is a no-op that
+// exists purely to make database/sql release the connection - without it
+// every shard touched by every WithinTx call would leak one pooled
+// connection, forever.
+func releaseConns(txs map[int]*sql.Tx) {
+	for shardID, sqlTx := range txs {
+		if err := sqlTx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("⚠️  failed to release connection for shard %d: %v", shardID, err)
+		}
+	}
+}
+
+// rollbackPrepared issues ROLLBACK PREPARED against every shard in
+// shardIDs, i.e. only the ones preparePhase actually got PREPARE
+// TRANSACTION to succeed on.
+func rollbackPrepared(sm *ShardManager, gid string, shardIDs []int) {
+	for _, shardID := range shardIDs {
+		db, ok := sm.shardByID(shardID)
+		if !ok {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ROLLBACK PREPARED '%s'", gid)); err != nil {
+			log.Printf("⚠️  ROLLBACK PREPARED on shard %d failed for %s: %v", shardID, gid, err)
+		}
+	}
+}
+
+// defaultCoordinator lazily creates, on first use, the coordinator that
+// WithinTx and RecoverOrphanedTransactions share: it logs on the
+// lowest-numbered known shard.
+func (sm *ShardManager) defaultCoordinator() (*ShardTxCoordinator, error) {
+	sm.mu.Lock()
+	if sm.txCoordinator == nil {
+		ids := sm.shardIDsLocked()
+		if len(ids) == 0 {
+			sm.mu.Unlock()
+			return nil, fmt.Errorf("no shards registered")
+		}
+		coordinatorShardID := ids[0]
+		for _, id := range ids {
+			if id < coordinatorShardID {
+				coordinatorShardID = id
+			}
+		}
+		sm.mu.Unlock()
+
+		coord, err := NewShardTxCoordinator(sm, coordinatorShardID)
+		if err != nil {
+			return nil, err
+		}
+
+		sm.mu.Lock()
+		if sm.txCoordinator == nil {
+			sm.txCoordinator = coord
+		}
+	}
+	coord := sm.txCoordinator
+	sm.mu.Unlock()
+	return coord, nil
+}
+
+// WithinTx is a convenience method so callers can write
+// sm.WithinTx(ctx, func(tx *ShardTx) error { ... tx.OnShard(userID).Exec(...) ... })
+// without holding onto a *ShardTxCoordinator themselves.
+func (sm *ShardManager) WithinTx(ctx context.Context, fn func(tx *ShardTx) error) error {
+	coord, err := sm.defaultCoordinator()
+	if err != nil {
+		return err
+	}
+	return coord.WithinTx(ctx, fn)
+}
+
+// RecoverOrphanedTransactions is the startup counterpart to WithinTx: call
+// it once before the server starts serving traffic so a crash between
+// PREPARE TRANSACTION and COMMIT/ROLLBACK PREPARED in a previous run gets
+// resolved before any new distributed transaction runs.
+func (sm *ShardManager) RecoverOrphanedTransactions(ctx context.Context) error {
+	coord, err := sm.defaultCoordinator()
+	if err != nil {
+		return err
+	}
+	return coord.RecoverOrphanedTransactions(ctx)
+}
+
+func parseParticipants(participants string) []int {
+	fields := strings.Split(participants, ",")
+	ids := make([]int, 0, len(fields))
+	for _, f := range fields {
+		id, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// RecoverOrphanedTransactions finds every transaction shard_tx_log still
+// lists as 'preparing' or 'committing' and, per gid, checks
+// pg_prepared_xacts on every shard logParticipants recorded as a
+// participant - not just whichever shards happen to still have the
+// prepared xact.
+//
+// The two statuses need different treatment, which is exactly why WithinTx
+// writes 'committing' before it starts calling COMMIT PREPARED: a gid stuck
+// at 'preparing' never got a commit decision, so if even one participant
+// never reached PREPARE TRANSACTION the whole thing must be rolled back.
+// But a gid at 'committing' already had the commit decided and every
+// participant successfully prepared - commitPrepared may simply have
+// crashed partway through, and a participant no longer in
+// pg_prepared_xacts means it already committed successfully, not that it
+// never prepared. Treating the two the same (the original bug) meant a
+// 'committing' gid that crashed after committing shard 0 but before shard 1
+// would see shard 0 missing from pg_prepared_xacts, conclude "not all
+// participants prepared", and issue ROLLBACK PREPARED on shard 1 - splitting
+// the transaction. Recovery instead retries COMMIT PREPARED on whatever
+// 'committing' participants are still outstanding and leaves already-gone
+// ones alone.
+func (c *ShardTxCoordinator) RecoverOrphanedTransactions(ctx context.Context) error {
+	logDB, ok := c.sm.shardByID(c.coordinatorShardID)
+	if !ok {
+		return fmt.Errorf("coordinator shard %d not found", c.coordinatorShardID)
+	}
+
+	rows, err := logDB.QueryContext(ctx,
+		`SELECT gid, participants, status FROM shard_tx_log WHERE status IN ('preparing', 'committing')`)
+	if err != nil {
+		return fmt.Errorf("failed to read shard_tx_log: %w", err)
+	}
+
+	type orphan struct {
+		gid          string
+		participants []int
+		status       string
+	}
+	var orphans []orphan
+	for rows.Next() {
+		var gid, participants, status string
+		if err := rows.Scan(&gid, &participants, &status); err != nil {
+			rows.Close()
+			return err
+		}
+		orphans = append(orphans, orphan{gid: gid, participants: parseParticipants(participants), status: status})
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+
+	var committed, rolledBack []string
+	for _, o := range orphans {
+		stillPrepared := make([]int, 0, len(o.participants))
+		allPrepared := true
+		for _, shardID := range o.participants {
+			db, ok := c.sm.shardByID(shardID)
+			if !ok {
+				allPrepared = false
+				continue
+			}
+			var found bool
+			if err := db.QueryRowContext(ctx,
+				`SELECT EXISTS(SELECT 1 FROM pg_prepared_xacts WHERE gid = $1)`, o.gid,
+			).Scan(&found); err != nil {
+				log.Printf("⚠️  recovery: failed to check pg_prepared_xacts for %s on shard %d: %v", o.gid, shardID, err)
+				allPrepared = false
+				continue
+			}
+			if found {
+				stillPrepared = append(stillPrepared, shardID)
+			} else {
+				allPrepared = false
+			}
+		}
+
+		if o.status == "committing" {
+			// The commit was already decided and every participant had
+			// successfully prepared before this status was written; anything
+			// missing from pg_prepared_xacts now already committed, so only
+			// retry the ones still outstanding.
+			if err := commitPrepared(c.sm, o.gid, stillPrepared); err != nil {
+				log.Printf("⚠️  recovery: commit retry failed for %s, will retry next startup: %v", o.gid, err)
+				continue
+			}
+			log.Printf("✅ recovery: finished committing orphaned transaction %s (%d/%d participant(s) still needed COMMIT PREPARED)", o.gid, len(stillPrepared), len(o.participants))
+			committed = append(committed, o.gid)
+			continue
+		}
+
+		if allPrepared {
+			if err := commitPrepared(c.sm, o.gid, stillPrepared); err != nil {
+				log.Printf("⚠️  recovery: commit failed for %s, will retry next startup: %v", o.gid, err)
+				continue
+			}
+			log.Printf("✅ recovery: committed orphaned transaction %s on all %d participant(s)", o.gid, len(stillPrepared))
+			committed = append(committed, o.gid)
+			continue
+		}
+
+		rollbackPrepared(c.sm, o.gid, stillPrepared)
+		log.Printf("✅ recovery: rolled back orphaned transaction %s (only %d/%d participant(s) had prepared)", o.gid, len(stillPrepared), len(o.participants))
+		rolledBack = append(rolledBack, o.gid)
+	}
+
+	if len(committed) > 0 {
+		if _, err := logDB.ExecContext(ctx,
+			`UPDATE shard_tx_log SET status = 'committed' WHERE status IN ('preparing', 'committing') AND gid = ANY($1)`,
+			pq.Array(committed),
+		); err != nil {
+			log.Printf("⚠️  recovery: failed to mark recovered transactions committed: %v", err)
+		}
+	}
+	if len(rolledBack) > 0 {
+		if _, err := logDB.ExecContext(ctx,
+			// Only 'preparing' gids ever reach the rollback path - see the
+			// status switch above - but the predicate matches the commit
+			// branch's shape for consistency.
+			`UPDATE shard_tx_log SET status = 'rolled_back' WHERE status IN ('preparing', 'committing') AND gid = ANY($1)`,
+			pq.Array(rolledBack),
+		); err != nil {
+			log.Printf("⚠️  recovery: failed to mark recovered transactions rolled back: %v", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/lib/pq"
+)
+
+const (
+	modeNoDuplicateUserID  = "no_duplicate_user_id"
+	modeRoutingDrift       = "routing_drift"
+	modeManifestRowCount   = "manifest_row_count"
+	modeManifestColumnHash = "manifest_column_hash"
+)
+
+// ModeResult is the outcome of a single check (no_duplicate_user_id,
+// routing_drift, manifest_row_count, manifest_column_hash) for one table on
+// one shard.
+type ModeResult struct {
+	Passed  bool
+	Message string
+	// Misplaced is populated by routing_drift: user IDs found on this shard
+	// that should now live elsewhere, so --fix can target them directly.
+	Misplaced []misplacedRow
+}
+
+type misplacedRow struct {
+	UserID       int
+	CorrectShard int
+}
+
+// TableResult groups every ModeResult for one table, addressable by check
+// mode name.
+type TableResult struct {
+	Modes map[string]*ModeResult
+}
+
+// SchemaResult groups TableResults for one schema, addressable by table
+// name.
+type SchemaResult struct {
+	Tables map[string]*TableResult
+}
+
+// DatabaseResult groups SchemaResults for one shard, addressable by schema
+// name. Only "public" is populated today, but the shape leaves room for
+// multi-schema deployments.
+type DatabaseResult struct {
+	Schemas map[string]*SchemaResult
+}
+
+// ClusterResult is the full nested result: shard ID -> DatabaseResult ->
+// SchemaResult -> TableResult -> ModeResult, so any single check can be
+// addressed as result[shardID].Schemas["public"].Tables["users"].Modes["routing_drift"].
+type ClusterResult map[int]*DatabaseResult
+
+func newDatabaseResult() *DatabaseResult {
+	return &DatabaseResult{Schemas: map[string]*SchemaResult{
+		"public": {Tables: map[string]*TableResult{
+			"users": {Modes: map[string]*ModeResult{}},
+		}},
+	}}
+}
+
+func (cr ClusterResult) usersTable(shardID int) *TableResult {
+	return cr[shardID].Schemas["public"].Tables["users"]
+}
+
+// Passed reports whether every check on every shard passed.
+func (cr ClusterResult) Passed() bool {
+	for _, db := range cr {
+		for _, schema := range db.Schemas {
+			for _, table := range schema.Tables {
+				for _, mode := range table.Modes {
+					if !mode.Passed {
+						return false
+					}
+				}
+			}
+		}
+	}
+	return true
+}
+
+// Report renders a human-readable summary, one line per shard/table/mode.
+func (cr ClusterResult) Report() string {
+	var b strings.Builder
+	shardIDs := make([]int, 0, len(cr))
+	for id := range cr {
+		shardIDs = append(shardIDs, id)
+	}
+	sort.Ints(shardIDs)
+
+	fmt.Fprintln(&b, "Shard Consistency Report")
+	fmt.Fprintln(&b, strings.Repeat("=", 60))
+	for _, id := range shardIDs {
+		fmt.Fprintf(&b, "\nShard %d:\n", id)
+		for schemaName, schema := range cr[id].Schemas {
+			for tableName, table := range schema.Tables {
+				modeNames := make([]string, 0, len(table.Modes))
+				for name := range table.Modes {
+					modeNames = append(modeNames, name)
+				}
+				sort.Strings(modeNames)
+				for _, name := range modeNames {
+					mode := table.Modes[name]
+					status := "OK"
+					if !mode.Passed {
+						status = "FAIL"
+					}
+					fmt.Fprintf(&b, "  [%s] %s.%s.%s: %s\n", status, schemaName, tableName, name, mode.Message)
+				}
+			}
+		}
+	}
+	return b.String()
+}
+
+// FixSQL reads each misplaced row's current data off its source shard and
+// emits, per shard, the literal statements an operator should run against
+// that shard's own connection: an INSERT (with quoted literal values) on
+// the shard the row belongs on, and a DELETE on the shard it doesn't. There
+// is no single statement that can move a row between shards directly -
+// unlike a multi-schema single-database setup, every shard here is its own
+// independently-DSN'd Postgres connection (see connect.go, and
+// sharding-poc's SHARD_N_DSN/SHARD_N_PRIMARY_DSN), so a cross-shard
+// INSERT...SELECT can't run without dblink/FDW. This mirrors the
+// select/insert/delete sharding-poc's resharding.go already does at the Go
+// level for live rebalances, just rendered as SQL instead of executed
+// directly. It does not execute anything - operators review and run each
+// shard's statements against that shard themselves.
+func (cr ClusterResult) FixSQL(shards map[int]*sql.DB) (map[int][]string, error) {
+	stmts := make(map[int][]string)
+	for shardID := range cr {
+		mode, ok := cr.usersTable(shardID).Modes[modeRoutingDrift]
+		if !ok {
+			continue
+		}
+		srcDB, ok := shards[shardID]
+		if !ok {
+			continue
+		}
+
+		for _, row := range mode.Misplaced {
+			var name, email, createdAt string
+			err := srcDB.QueryRow(
+				`SELECT name, email, created_at::text FROM users WHERE user_id = $1`, row.UserID,
+			).Scan(&name, &email, &createdAt)
+			if err != nil {
+				return stmts, fmt.Errorf("shard %d: failed to read misplaced user_id=%d: %w", shardID, row.UserID, err)
+			}
+
+			insert := fmt.Sprintf(
+				"-- user_id=%d: shard %d -> shard %d\n"+
+					"INSERT INTO users (user_id, name, email, created_at) VALUES (%d, %s, %s, %s) ON CONFLICT (user_id) DO NOTHING;",
+				row.UserID, shardID, row.CorrectShard,
+				row.UserID, pq.QuoteLiteral(name), pq.QuoteLiteral(email), pq.QuoteLiteral(createdAt),
+			)
+			del := fmt.Sprintf(
+				"-- user_id=%d: remove from shard %d now that it lives on shard %d\n"+
+					"DELETE FROM users WHERE user_id = %d;",
+				row.UserID, shardID, row.CorrectShard, row.UserID,
+			)
+
+			stmts[row.CorrectShard] = append(stmts[row.CorrectShard], insert)
+			stmts[shardID] = append(stmts[shardID], del)
+		}
+	}
+	return stmts, nil
+}
+
+// runChecks fans the per-shard scans out across a bounded worker pool, then
+// runs the cross-shard duplicate check once every shard has reported in.
+func runChecks(shards map[int]*sql.DB, manifest Manifest, concurrency int) ClusterResult {
+	shardIDs := make([]int, 0, len(shards))
+	for id := range shards {
+		shardIDs = append(shardIDs, id)
+	}
+
+	result := make(ClusterResult, len(shardIDs))
+	userIDsByShard := make(map[int][]int, len(shardIDs))
+	var mu sync.Mutex
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for _, shardID := range shardIDs {
+		wg.Add(1)
+		go func(shardID int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			db := shards[shardID]
+			dbResult := newDatabaseResult()
+			table := dbResult.Schemas["public"].Tables["users"]
+
+			userIDs, err := scanUserIDs(db)
+			if err != nil {
+				table.Modes[modeRoutingDrift] = &ModeResult{Message: fmt.Sprintf("scan failed: %v", err)}
+				mu.Lock()
+				result[shardID] = dbResult
+				mu.Unlock()
+				return
+			}
+
+			table.Modes[modeRoutingDrift] = checkRoutingDrift(shardIDs, shardID, userIDs)
+
+			if exp, ok := manifest.forShard(shardID, "public.users"); ok {
+				table.Modes[modeManifestRowCount] = checkRowCount(db, exp)
+				table.Modes[modeManifestColumnHash] = checkColumnHash(db, exp)
+			}
+
+			mu.Lock()
+			result[shardID] = dbResult
+			userIDsByShard[shardID] = userIDs
+			mu.Unlock()
+		}(shardID)
+	}
+	wg.Wait()
+
+	for shardID, mode := range checkNoDuplicates(userIDsByShard) {
+		result.usersTable(shardID).Modes[modeNoDuplicateUserID] = mode
+	}
+
+	return result
+}
+
+func scanUserIDs(db *sql.DB) ([]int, error) {
+	rows, err := db.Query(`SELECT user_id FROM users`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// checkRoutingDrift recomputes where each user_id should live under the
+// current ring and flags anything that has drifted since the last
+// rebalance.
+func checkRoutingDrift(shardIDs []int, shardID int, userIDs []int) *ModeResult {
+	var misplaced []misplacedRow
+	for _, userID := range userIDs {
+		if want := expectedShard(shardIDs, userID); want != shardID {
+			misplaced = append(misplaced, misplacedRow{UserID: userID, CorrectShard: want})
+		}
+	}
+
+	if len(misplaced) == 0 {
+		return &ModeResult{Passed: true, Message: fmt.Sprintf("all %d rows on their correct shard", len(userIDs))}
+	}
+	return &ModeResult{
+		Passed:    false,
+		Message:   fmt.Sprintf("%d of %d rows belong on a different shard", len(misplaced), len(userIDs)),
+		Misplaced: misplaced,
+	}
+}
+
+// checkNoDuplicates finds any user_id present on more than one shard.
+func checkNoDuplicates(userIDsByShard map[int][]int) map[int]*ModeResult {
+	owner := make(map[int]int) // user_id -> first shard seen
+	dupeShards := make(map[int]bool)
+
+	for shardID, ids := range userIDsByShard {
+		for _, id := range ids {
+			if first, seen := owner[id]; seen && first != shardID {
+				dupeShards[first] = true
+				dupeShards[shardID] = true
+			} else if !seen {
+				owner[id] = shardID
+			}
+		}
+	}
+
+	results := make(map[int]*ModeResult, len(userIDsByShard))
+	for shardID := range userIDsByShard {
+		if dupeShards[shardID] {
+			results[shardID] = &ModeResult{Passed: false, Message: "one or more user_ids also found on another shard"}
+		} else {
+			results[shardID] = &ModeResult{Passed: true, Message: "no user_id collisions with other shards"}
+		}
+	}
+	return results
+}
+
+func checkRowCount(db *sql.DB, exp TableExpectation) *ModeResult {
+	var count int64
+	if err := db.QueryRow(`SELECT count(*) FROM users`).Scan(&count); err != nil {
+		return &ModeResult{Message: fmt.Sprintf("count query failed: %v", err)}
+	}
+	if count != exp.RowCount {
+		return &ModeResult{Passed: false, Message: fmt.Sprintf("expected %d rows, found %d", exp.RowCount, count)}
+	}
+	return &ModeResult{Passed: true, Message: fmt.Sprintf("row count matches manifest (%d)", count)}
+}
+
+func checkColumnHash(db *sql.DB, exp TableExpectation) *ModeResult {
+	var hash sql.NullString
+	query := `SELECT md5(coalesce(string_agg(user_id::text || name || email, '' ORDER BY user_id), '')) FROM users`
+	if err := db.QueryRow(query).Scan(&hash); err != nil {
+		return &ModeResult{Message: fmt.Sprintf("hash query failed: %v", err)}
+	}
+	if hash.String != exp.ColumnHash {
+		return &ModeResult{Passed: false, Message: fmt.Sprintf("expected column hash %s, found %s", exp.ColumnHash, hash.String)}
+	}
+	return &ModeResult{Passed: true, Message: "column hash matches manifest"}
+}
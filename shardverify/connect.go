@@ -0,0 +1,36 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+
+	_ "github.com/lib/pq"
+)
+
+// connectShards opens a *sql.DB for each shard's primary, mirroring
+// sharding-poc's NewShardManager so operators can point this tool at the
+// same cluster with the same env layout: SHARD_N_PRIMARY_DSN, falling back
+// to the legacy SHARD_N_DSN for clusters that haven't moved to the
+// primary/replica layout yet. It never talks to replicas - verification
+// needs to see each shard's authoritative state, not a possibly-lagging copy.
+func connectShards(numShards int) (map[int]*sql.DB, error) {
+	shards := make(map[int]*sql.DB, numShards)
+
+	for i := 0; i < numShards; i++ {
+		dsn := os.Getenv(fmt.Sprintf("SHARD_%d_PRIMARY_DSN", i))
+		if dsn == "" {
+			dsn = os.Getenv(fmt.Sprintf("SHARD_%d_DSN", i))
+		}
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open shard %d: %w", i, err)
+		}
+		if err := db.Ping(); err != nil {
+			return nil, fmt.Errorf("shard %d ping failed: %w", i, err)
+		}
+		shards[i] = db
+	}
+
+	return shards, nil
+}
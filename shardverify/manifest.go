@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// TableExpectation is what operators expect a table to look like after the
+// last known-good rebalance: a row count and a stable hash over its rows
+// (e.g. sum of md5(row::text)) so drift is detectable without comparing
+// full table contents.
+type TableExpectation struct {
+	RowCount   int64  `json:"row_count"`
+	ColumnHash string `json:"column_hash"`
+}
+
+// Manifest maps shard ID to that shard's expected per-"schema.table" shape.
+// Sharding puts different rows on different shards, so row counts and
+// column hashes are only meaningful per shard - there is no single
+// cluster-wide expectation for a table. An empty manifest (no -manifest
+// flag given) just skips the manifest-comparison checks.
+type Manifest map[int]map[string]TableExpectation
+
+// forShard returns the expectation for schema.table on shardID, if the
+// manifest has one.
+func (m Manifest) forShard(shardID int, table string) (TableExpectation, bool) {
+	exp, ok := m[shardID][table]
+	return exp, ok
+}
+
+func loadManifest(path string) (Manifest, error) {
+	if path == "" {
+		return Manifest{}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
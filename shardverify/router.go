@@ -0,0 +1,42 @@
+package main
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// vnodesPerShard mirrors sharding-poc's ConsistentHashRouter default so this
+// tool recomputes the same routing decisions the live server would make.
+const vnodesPerShard = 150
+
+// expectedShard recreates the consistent-hash ring over shardIDs and
+// returns which shard currently owns userID. Kept as a small standalone
+// copy (rather than importing sharding-poc) since this tool has no build
+// dependency on the API server - only on agreeing with its hashing scheme.
+func expectedShard(shardIDs []int, userID int) int {
+	ring := make([]uint64, 0, len(shardIDs)*vnodesPerShard)
+	ringShard := make(map[uint64]int, len(shardIDs)*vnodesPerShard)
+
+	for _, shardID := range shardIDs {
+		for v := 0; v < vnodesPerShard; v++ {
+			h := hashKey(strconv.Itoa(shardID) + "#" + strconv.Itoa(v))
+			ring = append(ring, h)
+			ringShard[h] = shardID
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i] < ring[j] })
+
+	h := hashKey(strconv.Itoa(userID))
+	idx := sort.Search(len(ring), func(i int) bool { return ring[i] >= h })
+	if idx == len(ring) {
+		idx = 0
+	}
+	return ringShard[ring[idx]]
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum64()
+}